@@ -0,0 +1,118 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// IdentityRecord is one entry in the on-chain miner identity registry: a binding of a
+// FactomDigitalID to the Ed25519 public key it is currently using. Rotating to a new key
+// requires PrevSignature, the outgoing key's signature over the new key, so a hijacker who
+// doesn't hold the current key can't steal an identity out from under its miner.
+type IdentityRecord struct {
+	FactomDigitalID []string `json:"factomdigitalid"`
+	PubKey          []byte   `json:"pubkey"`
+	PrevSignature   []byte   `json:"prevsignature,omitempty"`
+}
+
+// IdentityRegistry is an in-memory read model of the identity-registration chain: a Factom
+// chain where each entry is an IdentityRecord. Populating it is reading/replaying that
+// chain's entries and calling Apply as they're encountered. NewRegistration/NewRotation build
+// the entry content a `pegnet identity register`/`pegnet identity rotate` command would write
+// to that chain; this trimmed tree has no cmd/ front end (or factom entry-submission code at
+// all, for any chain) to host those commands, so wiring them up is left to that front end.
+type IdentityRegistry struct {
+	mu      sync.RWMutex
+	current map[string][]byte // digitalID(FactomDigitalID) -> current PubKey
+}
+
+// NewIdentityRegistry returns an empty registry.
+func NewIdentityRegistry() *IdentityRegistry {
+	return &IdentityRegistry{current: make(map[string][]byte)}
+}
+
+// Identities is the process-wide identity registry consulted by opr.Validate when
+// `[Miner] EnforceIdentity` is set.
+var Identities = NewIdentityRegistry()
+
+func digitalID(fields []string) string {
+	return strings.Join(fields, "-")
+}
+
+// Register binds did to pubKey for the first time. It fails if did is already registered;
+// use Rotate to replace an existing key.
+func (r *IdentityRegistry) Register(did []string, pubKey []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := digitalID(did)
+	if _, exists := r.current[key]; exists {
+		return fmt.Errorf("identity %q is already registered", key)
+	}
+	r.current[key] = append([]byte(nil), pubKey...)
+	return nil
+}
+
+// Rotate replaces did's registered key with newPubKey, provided signature verifies as the
+// currently-registered key's Ed25519 signature over newPubKey - i.e. the existing key holder
+// authorized the rotation.
+func (r *IdentityRegistry) Rotate(did []string, newPubKey, signature []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := digitalID(did)
+	existing, ok := r.current[key]
+	if !ok {
+		return fmt.Errorf("identity %q is not registered", key)
+	}
+	if !ed25519.Verify(existing, newPubKey, signature) {
+		return fmt.Errorf("rotation signature for identity %q does not verify against its current key", key)
+	}
+	r.current[key] = append([]byte(nil), newPubKey...)
+	return nil
+}
+
+// Lookup returns the currently-registered PubKey for did, if any.
+func (r *IdentityRegistry) Lookup(did []string) ([]byte, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	pk, ok := r.current[digitalID(did)]
+	return pk, ok
+}
+
+// Apply replays rec against the registry: a record with no PrevSignature is a first-time
+// Register, one with a PrevSignature is a Rotate. This is the single entry point a chain
+// reader should call for every IdentityRecord it encounters while replaying the
+// identity-registration chain. NewRegistration/NewRotation build records in the form it
+// takes.
+func (r *IdentityRegistry) Apply(rec *IdentityRecord) error {
+	if len(rec.PrevSignature) == 0 {
+		return r.Register(rec.FactomDigitalID, rec.PubKey)
+	}
+	return r.Rotate(rec.FactomDigitalID, rec.PubKey, rec.PrevSignature)
+}
+
+// NewRegistration builds the IdentityRecord for a first-time binding of did to priv's public
+// key - the entry content a `pegnet identity register` command would write to the
+// identity-registration chain.
+func NewRegistration(did []string, priv ed25519.PrivateKey) *IdentityRecord {
+	return &IdentityRecord{
+		FactomDigitalID: did,
+		PubKey:          append([]byte(nil), priv.Public().(ed25519.PublicKey)...),
+	}
+}
+
+// NewRotation builds the IdentityRecord that replaces did's registered key with newPriv's
+// public key, signing newPriv's public key with oldPriv so the registry can verify the
+// outgoing key authorized the rotation. This is the entry content a `pegnet identity rotate`
+// command would write to the identity-registration chain.
+func NewRotation(did []string, oldPriv ed25519.PrivateKey, newPriv ed25519.PrivateKey) *IdentityRecord {
+	newPub := append([]byte(nil), newPriv.Public().(ed25519.PublicKey)...)
+	return &IdentityRecord{
+		FactomDigitalID: did,
+		PubKey:          newPub,
+		PrevSignature:   ed25519.Sign(oldPriv, newPub),
+	}
+}
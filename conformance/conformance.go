@@ -0,0 +1,183 @@
+// Package conformance checks the live OraclePriceRecord implementation (hashing, difficulty,
+// validation) against a corpus of recorded vectors under testdata/vectors, so a refactor of
+// GetHash, ComputeDifficulty, Validate, or the underlying LXRHash parameters can't silently
+// fork the network. The corpus doubles as a machine-checkable spec for alternative (non-Go)
+// miner/grader implementations.
+package conformance
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/FactomProject/factom"
+	"github.com/pegnet/pegnet/opr"
+	"github.com/zpatrick/go-config"
+)
+
+// Vector is one consensus-critical conformance check: an OPR JSON blob plus a mined nonce,
+// and the outputs our implementation is expected to produce for them.
+type Vector struct {
+	Name               string          `json:"name"`
+	OPR                json.RawMessage `json:"opr"`
+	NonceHex           string          `json:"nonce_hex"`
+	ExpectedOPRHashHex string          `json:"expected_opr_hash_hex"`
+	ExpectedDifficulty uint64          `json:"expected_difficulty"`
+	ExpectedValid      bool            `json:"expected_valid"`
+
+	// Peers, if non-empty, are other OPRs sharing OPR's block, used to exercise GradeOPRs'
+	// winner-selection ranking - explicitly consensus-critical, since it decides whose
+	// WinPreviousOPR gets recorded, and so worth conformance-checking alongside hashing,
+	// difficulty, and validation.
+	Peers []PeerVector `json:"peers,omitempty"`
+	// ExpectedGrade is OPR's expected 1-indexed rank (opr.Grade) once GradeOPRs ranks it
+	// alongside Peers. Meaningless, and ignored by Check, when Peers is empty.
+	ExpectedGrade float64 `json:"expected_grade,omitempty"`
+}
+
+// PeerVector is one other OPR in the same block as a Vector's OPR: just enough to reconstruct
+// its Difficulty and OPRHash for GradeOPRs, without the rest of Vector's single-record checks.
+type PeerVector struct {
+	OPR      json.RawMessage `json:"opr"`
+	NonceHex string          `json:"nonce_hex"`
+}
+
+// LoadVectors reads every *.json file in dir as a single Vector and returns them sorted by
+// Name, so a corpus built from many small files still runs in a deterministic order.
+func LoadVectors(dir string) ([]Vector, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([]Vector, 0, len(paths))
+	for _, p := range paths {
+		raw, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", p, err)
+		}
+		var v Vector
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", p, err)
+		}
+		vectors = append(vectors, v)
+	}
+
+	sort.Slice(vectors, func(i, j int) bool { return vectors[i].Name < vectors[j].Name })
+	return vectors, nil
+}
+
+// Mismatch describes one field where a Vector's expectation and our implementation's actual
+// output disagreed.
+type Mismatch struct {
+	Vector   string
+	Field    string
+	Expected interface{}
+	Actual   interface{}
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: %s expected %v, got %v", m.Vector, m.Field, m.Expected, m.Actual)
+}
+
+// Check runs a single vector against the live implementation, returning every field that
+// disagreed with the vector's expectation (nil if it matched completely).
+func Check(v Vector, c *config.Config) ([]Mismatch, error) {
+	opr.InitLX()
+
+	record := new(opr.OraclePriceRecord)
+	if err := json.Unmarshal(v.OPR, record); err != nil {
+		return nil, fmt.Errorf("unmarshaling vector OPR: %v", err)
+	}
+	record.Entry = &factom.Entry{ExtIDs: [][]byte{{}}}
+	record.OPRHash = record.GetHash()
+
+	var mismatches []Mismatch
+	if got := hex.EncodeToString(record.OPRHash); got != v.ExpectedOPRHashHex {
+		mismatches = append(mismatches, Mismatch{v.Name, "OPRHash", v.ExpectedOPRHashHex, got})
+	}
+
+	nonce, err := hex.DecodeString(v.NonceHex)
+	if err != nil {
+		return nil, fmt.Errorf("decoding nonce_hex: %v", err)
+	}
+	record.Difficulty = record.ComputeDifficulty(nonce)
+	if got := record.Difficulty; got != v.ExpectedDifficulty {
+		mismatches = append(mismatches, Mismatch{v.Name, "Difficulty", v.ExpectedDifficulty, got})
+	}
+
+	if got := record.Validate(c); got != v.ExpectedValid {
+		mismatches = append(mismatches, Mismatch{v.Name, "Validate", v.ExpectedValid, got})
+	}
+
+	if len(v.Peers) > 0 {
+		block := []*opr.OraclePriceRecord{record}
+		for i, p := range v.Peers {
+			peer := new(opr.OraclePriceRecord)
+			if err := json.Unmarshal(p.OPR, peer); err != nil {
+				return nil, fmt.Errorf("unmarshaling peer %d OPR: %v", i, err)
+			}
+			peer.Entry = &factom.Entry{ExtIDs: [][]byte{{}}}
+			peer.OPRHash = peer.GetHash()
+
+			peerNonce, err := hex.DecodeString(p.NonceHex)
+			if err != nil {
+				return nil, fmt.Errorf("decoding peer %d nonce_hex: %v", i, err)
+			}
+			peer.Difficulty = peer.ComputeDifficulty(peerNonce)
+			block = append(block, peer)
+		}
+
+		opr.GradeOPRs(block)
+		if got := record.Grade; got != v.ExpectedGrade {
+			mismatches = append(mismatches, Mismatch{v.Name, "Grade", v.ExpectedGrade, got})
+		}
+	}
+
+	return mismatches, nil
+}
+
+// Generate builds a fresh Vector from o, recording whatever the current implementation
+// produces as the expectation. o.Entry.ExtIDs[0] is taken as the mined nonce. peers, if given,
+// are other OPRs in o's block (already mined, Entry.ExtIDs[0] set) and Generate records o's
+// GradeOPRs rank among them; pass nil to skip grade-rank checking for this vector. This is the
+// library call behind the `pegnet vectors generate` CLI mode: point it at known-good OPRs and
+// commit the result under testdata/vectors/ so future builds are checked against it.
+func Generate(name string, o *opr.OraclePriceRecord, peers []*opr.OraclePriceRecord, c *config.Config) (Vector, error) {
+	raw, err := json.Marshal(o)
+	if err != nil {
+		return Vector{}, fmt.Errorf("marshaling OPR: %v", err)
+	}
+
+	oprHash := o.GetHash()
+	nonce := o.Entry.ExtIDs[0]
+	v := Vector{
+		Name:               name,
+		OPR:                raw,
+		NonceHex:           hex.EncodeToString(nonce),
+		ExpectedOPRHashHex: hex.EncodeToString(oprHash),
+		ExpectedDifficulty: o.ComputeDifficulty(nonce),
+		ExpectedValid:      o.Validate(c),
+	}
+	if len(peers) == 0 {
+		return v, nil
+	}
+
+	o.Difficulty = v.ExpectedDifficulty
+	block := append([]*opr.OraclePriceRecord{o}, peers...)
+	for _, peer := range block[1:] {
+		peer.Difficulty = peer.ComputeDifficulty(peer.Entry.ExtIDs[0])
+		peerRaw, err := json.Marshal(peer)
+		if err != nil {
+			return Vector{}, fmt.Errorf("marshaling peer OPR: %v", err)
+		}
+		v.Peers = append(v.Peers, PeerVector{OPR: peerRaw, NonceHex: hex.EncodeToString(peer.Entry.ExtIDs[0])})
+	}
+	opr.GradeOPRs(block)
+	v.ExpectedGrade = o.Grade
+
+	return v, nil
+}
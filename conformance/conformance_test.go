@@ -0,0 +1,38 @@
+package conformance
+
+import (
+	"testing"
+
+	"github.com/pegnet/pegnet/common"
+)
+
+// TestVectors runs every vector under testdata/vectors against the live OPR implementation.
+// A failure here means this build no longer agrees with the committed consensus spec -
+// either the vectors are stale, or (far more concerning) a refactor silently changed
+// GetHash, ComputeDifficulty, or Validate.
+//
+// The corpus starts empty; seed it by running known-good OPRs through Generate (the
+// `pegnet vectors generate` CLI mode) and committing the resulting files here.
+func TestVectors(t *testing.T) {
+	vectors, err := LoadVectors("testdata/vectors")
+	if err != nil {
+		t.Fatalf("loading vectors: %v", err)
+	}
+	if len(vectors) == 0 {
+		t.Skip("no vectors committed under testdata/vectors - see testdata/vectors/README.md")
+	}
+
+	c := common.NewUnitTestConfig()
+	for _, v := range vectors {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			mismatches, err := Check(v, c)
+			if err != nil {
+				t.Fatalf("checking vector: %v", err)
+			}
+			for _, m := range mismatches {
+				t.Error(m)
+			}
+		})
+	}
+}
@@ -2,17 +2,40 @@ package networkMiner
 
 import (
 	"crypto/tls"
-	"encoding/gob"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
+	"time"
+
+	"github.com/zpatrick/go-config"
 
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	_ = iota
-	FactomdEventForward
-)
+// CurrentProtoVersion is the wire protocol version this build speaks. A client whose
+// HelloPayload.ProtoVersion does not match is Nack'd and disconnected during the handshake.
+const CurrentProtoVersion = 1
+
+// maxFrameSize bounds a single framed message. It exists so a malformed or hostile peer
+// (bad length prefix, or a legitimately oversized payload) can only ever cost us this
+// much memory, and only that one connection gets dropped.
+const maxFrameSize = 16 * 1024 * 1024 // 16 MiB
+
+// HelloPayload is the Data of the first NetworkMessage a client must send after connecting.
+// Codec names the wire codec (see wire.go) the sender would like to use for every message
+// after the handshake. The server either accepts it, answering with an Ack (no payload) encoded
+// in that codec, or answers with a Nack carrying the reason, encoded in the bootstrap codec.
+type HelloPayload struct {
+	ProtoVersion int
+	MinerID      string
+	Nonce        uint64
+	Codec        string
+}
 
 type NetworkMessage struct {
 	NetworkCommand int
@@ -23,10 +46,10 @@ type TCPClient struct {
 	// Miner related fields
 	//PegnetMinerFields
 
-	id      int // Random
+	id      int // Random, or the Nonce from the client's Hello once the handshake completes
+	minerID string
 	conn    net.Conn
-	encoder *gob.Encoder
-	decoder *gob.Decoder
+	codec   Codec // Negotiated during the handshake; nil until then, bootstrapCodec is used instead
 	Server  *TCPServer
 }
 
@@ -34,36 +57,149 @@ func NewTCPClient(conn net.Conn, s *TCPServer) *TCPClient {
 	m := new(TCPClient)
 	m.conn = conn
 	m.Server = s
-	m.init()
 	m.id = rand.Int()
 
 	return m
 }
 
-func (c *TCPClient) init() {
-	c.decoder = gob.NewDecoder(c.conn)
-	c.encoder = gob.NewEncoder(c.conn)
+// writeFrame writes payload to w prefixed with its length as a big-endian uint32.
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds max frame size %d", len(payload), maxFrameSize)
+	}
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads a single length-prefixed frame from r, rejecting anything claiming to be
+// larger than maxFrameSize before ever allocating a buffer for it.
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("incoming frame of %d bytes exceeds max frame size %d", n, maxFrameSize)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// handshake is run once, right after accept, before the client is handed to the server's
+// callbacks or allowed to exchange any mining traffic. It enforces the protocol version,
+// negotiates the post-handshake wire codec and, when the server requires mutual TLS, that the
+// peer actually presented a client certificate.
+func (c *TCPClient) handshake() error {
+	payload, err := readFrame(c.conn)
+	if err != nil {
+		return fmt.Errorf("reading hello: %v", err)
+	}
+
+	m, err := bootstrapCodec.Decode(payload)
+	if err != nil {
+		return fmt.Errorf("decoding hello: %v", err)
+	}
+
+	if m.NetworkCommand != Hello {
+		return fmt.Errorf("expected Hello as first message, got command %d", m.NetworkCommand)
+	}
+
+	hello, ok := m.Data.(HelloPayload)
+	if !ok {
+		return errors.New("hello payload was not a HelloPayload")
+	}
+
+	if hello.ProtoVersion != CurrentProtoVersion {
+		c.nackBootstrap(fmt.Sprintf("unsupported protocol version %d, server speaks %d", hello.ProtoVersion, CurrentProtoVersion))
+		return fmt.Errorf("protocol version mismatch: peer wants %d, we speak %d", hello.ProtoVersion, CurrentProtoVersion)
+	}
+
+	codec, err := CodecByName(hello.Codec)
+	if err != nil {
+		c.nackBootstrap(err.Error())
+		return fmt.Errorf("negotiating codec: %v", err)
+	}
+
+	if c.Server.config != nil && c.Server.config.ClientAuth == tls.RequireAndVerifyClientCert {
+		tlsConn, ok := c.conn.(*tls.Conn)
+		if !ok || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+			c.nackBootstrap("client certificate required")
+			return errors.New("mutual TLS required but peer presented no client certificate")
+		}
+	}
+
+	c.id = int(hello.Nonce)
+	c.minerID = hello.MinerID
+	c.codec = codec
+	return c.SendNetworkCommand(&NetworkMessage{NetworkCommand: Ack})
+}
+
+// nackBootstrap sends a Nack using the bootstrap codec, since the real codec hasn't been
+// negotiated yet (or negotiation is exactly what failed).
+func (c *TCPClient) nackBootstrap(reason string) {
+	payload, err := bootstrapCodec.Encode(&NetworkMessage{NetworkCommand: Nack, Data: reason})
+	if err != nil {
+		return
+	}
+	_ = writeFrame(c.conn, payload)
 }
 
 // Read client data from channel
 func (c *TCPClient) listen() {
+	if err := c.handshake(); err != nil {
+		log.WithError(err).WithField("remote", c.conn.RemoteAddr()).Warn("dropping client, handshake failed")
+		c.conn.Close()
+		c.Server.onClientConnectionClosed(c, err)
+		return
+	}
+
 	c.Server.onNewClientCallback(c)
 	for {
-		var m NetworkMessage
-		err := c.decoder.Decode(&m)
+		payload, err := readFrame(c.conn)
 		if err != nil {
 			c.conn.Close()
 			c.Server.onClientConnectionClosed(c, err)
 			return
 		}
-		c.Server.onNewMessage(c, &m)
+
+		m, err := c.codec.Decode(payload)
+		if err != nil {
+			// Includes unrecognized opcodes (payloadKind in wire.go) - we Nack and keep the
+			// connection open rather than closing it, so one peer sending a message from a
+			// newer protocol version doesn't take down the whole session.
+			log.WithError(err).WithField("remote", c.conn.RemoteAddr()).Warn("dropping malformed or unrecognized message")
+			_ = c.SendNetworkCommand(&NetworkMessage{NetworkCommand: Nack, Data: err.Error()})
+			continue
+		}
+		c.Server.onNewMessage(c, m)
 	}
 }
 
-// SendNetworkCommand text message to client
+// SendNetworkCommand encodes message with the connection's negotiated codec (or the bootstrap
+// codec, before the handshake completes) and writes it as a single length-prefixed frame.
 func (c *TCPClient) SendNetworkCommand(message *NetworkMessage) error {
-	err := c.encoder.Encode(message)
-	return err
+	codec := c.codec
+	if codec == nil {
+		codec = bootstrapCodec
+	}
+
+	payload, err := codec.Encode(message)
+	if err != nil {
+		return err
+	}
+	return writeFrame(c.conn, payload)
 }
 
 func (c *TCPClient) Conn() net.Conn {
@@ -117,9 +253,9 @@ func (s *TCPServer) Listen() {
 		conn, err := listener.Accept()
 		if err != nil {
 			log.WithError(err).Error("failed to accept client")
+			continue
 		}
 		client := NewTCPClient(conn, s)
-		client.init()
 		go client.listen()
 	}
 }
@@ -137,4 +273,204 @@ func NewTCPServer(host string) *TCPServer {
 	server.OnClientConnectionClosed(func(c *TCPClient, err error) {})
 
 	return server
-}
\ No newline at end of file
+}
+
+// NewTCPServerTLS creates a new tcp server instance that accepts only TLS connections using
+// cfg. Build cfg with TLSConfigFromConfig to load certificates from the [NetworkMiner]
+// section, or construct one by hand for finer control.
+func NewTCPServerTLS(host string, cfg *tls.Config) *TCPServer {
+	log.Println("Creating TLS server with address", host)
+	server := &TCPServer{
+		Host:   host,
+		config: cfg,
+	}
+
+	server.OnNewClient(func(c *TCPClient) {})
+	server.OnNewMessage(func(c *TCPClient, message *NetworkMessage) {})
+	server.OnClientConnectionClosed(func(c *TCPClient, err error) {})
+
+	return server
+}
+
+// TLSConfigFromConfig builds a *tls.Config for NewTCPServerTLS from the [NetworkMiner]
+// section of the miner config:
+//
+//	Cert              PEM file with the server certificate
+//	Key               PEM file with the server private key
+//	CAFile            PEM file of CA certificate(s) trusted to sign client certificates
+//	RequireClientAuth if true, clients must present a certificate signed by CAFile
+//
+// It returns (nil, nil) when Cert is unset, meaning the caller should fall back to plain TCP.
+func TLSConfigFromConfig(c *config.Config) (*tls.Config, error) {
+	certFile, err := c.String("NetworkMiner.Cert")
+	if err != nil || certFile == "" {
+		return nil, nil
+	}
+
+	keyFile, err := c.String("NetworkMiner.Key")
+	if err != nil || keyFile == "" {
+		return nil, errors.New("NetworkMiner.Cert is set but NetworkMiner.Key is not")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server keypair: %v", err)
+	}
+
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	requireClientAuth, _ := c.Bool("NetworkMiner.RequireClientAuth")
+
+	caFile, caErr := c.String("NetworkMiner.CAFile")
+	if caErr == nil && caFile != "" {
+		pemBytes, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading NetworkMiner.CAFile: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("no certificates found in NetworkMiner.CAFile %s", caFile)
+		}
+		tlsConfig.ClientCAs = pool
+
+		if requireClientAuth {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	} else if requireClientAuth {
+		return nil, errors.New("NetworkMiner.RequireClientAuth is true but NetworkMiner.CAFile is not set")
+	}
+
+	return tlsConfig, nil
+}
+
+// ClientTLSConfigFromConfig builds a *tls.Config for DialTCPClient from the [NetworkMiner]
+// section of the miner config:
+//
+//	ClientCert/ClientKey PEM files for this client's own certificate+key, presented when the
+//	                      server requires mutual TLS (RequireClientAuth=true server-side)
+//	CAFile                PEM file of CA certificate(s) trusted to verify the server's
+//	                      certificate
+//
+// It returns (nil, nil) when CAFile is unset, meaning the caller should fall back to plain
+// TCP (or build a *tls.Config by hand, e.g. for a self-signed dev server).
+func ClientTLSConfigFromConfig(c *config.Config) (*tls.Config, error) {
+	caFile, err := c.String("NetworkMiner.CAFile")
+	if err != nil || caFile == "" {
+		return nil, nil
+	}
+
+	pemBytes, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading NetworkMiner.CAFile: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in NetworkMiner.CAFile %s", caFile)
+	}
+
+	tlsConfig := &tls.Config{RootCAs: pool}
+
+	certFile, certErr := c.String("NetworkMiner.ClientCert")
+	keyFile, keyErr := c.String("NetworkMiner.ClientKey")
+	if certErr == nil && keyErr == nil && certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client keypair: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// DialTCPClient connects to a TCPServer at host and performs the version handshake,
+// identifying itself with minerID and proposing the codec named by `[NetworkMiner] Codec`
+// (falling back to the binary codec when unset, matching CodecByName). tlsConfig may be nil
+// for a plain TCP connection. If the dial or handshake fails, it retries with exponential
+// backoff (capped at dialMaxBackoff) until it succeeds, so a coordinator restart or network
+// blip doesn't require miner intervention.
+func DialTCPClient(host string, tlsConfig *tls.Config, minerID string, c *config.Config) (*TCPClient, error) {
+	backoff := dialMinBackoff
+	for {
+		client, err := dialTCPClientOnce(host, tlsConfig, minerID, c)
+		if err == nil {
+			return client, nil
+		}
+
+		log.WithError(err).WithField("host", host).Warn("failed to connect, retrying")
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > dialMaxBackoff {
+			backoff = dialMaxBackoff
+		}
+	}
+}
+
+const (
+	dialMinBackoff = 500 * time.Millisecond
+	dialMaxBackoff = 30 * time.Second
+)
+
+// dialTCPClientOnce dials host once and performs the handshake, proposing the codec named by
+// `[NetworkMiner] Codec` for all traffic after the Hello/Ack exchange (the Hello itself is
+// always sent with the bootstrap codec, since the codec hasn't been negotiated yet).
+func dialTCPClientOnce(host string, tlsConfig *tls.Config, minerID string, c *config.Config) (*TCPClient, error) {
+	codecName, _ := c.String("NetworkMiner.Codec")
+	codec, err := CodecByName(codecName)
+	if err != nil {
+		return nil, fmt.Errorf("NetworkMiner.Codec: %v", err)
+	}
+
+	var conn net.Conn
+	if tlsConfig != nil {
+		conn, err = tls.Dial("tcp", host, tlsConfig)
+	} else {
+		conn, err = net.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dial: %v", err)
+	}
+
+	client := &TCPClient{conn: conn, id: rand.Int()}
+	hello := HelloPayload{ProtoVersion: CurrentProtoVersion, MinerID: minerID, Nonce: uint64(rand.Int63()), Codec: codec.Name()}
+	if err := client.SendNetworkCommand(&NetworkMessage{NetworkCommand: Hello, Data: hello}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sending hello: %v", err)
+	}
+
+	payload, err := readFrame(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading handshake response: %v", err)
+	}
+
+	// A success Ack is encoded with our proposed codec (the server switches to it the moment
+	// it accepts the Hello), but a Nack sent before that point - a bad protocol version or an
+	// unsupported codec name - is still encoded with the bootstrap codec. Try our codec first
+	// and fall back, rather than guessing which failure mode we hit.
+	m, err := codec.Decode(payload)
+	if err != nil {
+		if fallback, fallbackErr := bootstrapCodec.Decode(payload); fallbackErr == nil {
+			m = fallback
+		} else {
+			conn.Close()
+			return nil, fmt.Errorf("decoding handshake response: %v", err)
+		}
+	}
+
+	switch m.NetworkCommand {
+	case Ack:
+		client.codec = codec
+		return client, nil
+	case Nack:
+		conn.Close()
+		return nil, fmt.Errorf("server rejected handshake: %v", m.Data)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unexpected handshake response command %d", m.NetworkCommand)
+	}
+}
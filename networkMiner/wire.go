@@ -0,0 +1,347 @@
+package networkMiner
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Message opcodes. Hello/Ack/Nack were introduced for the handshake in an earlier change;
+// the rest are the typed payloads that replace the old untyped, gob-encoded NetworkMessage.
+const (
+	_ = iota
+	FactomdEventForward
+	Hello
+	Ack
+	Nack
+	NewOPRJobCmd
+	SubmitCmd
+	Ping
+	Pong
+)
+
+// NewOPRJob announces a new OraclePriceRecord for miners to work on.
+type NewOPRJob struct {
+	OPRHash []byte
+	Dbht    int32
+	Target  uint64
+	JobID   string
+}
+
+// Submit reports a miner's best nonce for a job back to the coordinator.
+type Submit struct {
+	JobID      string
+	Nonce      []byte
+	Difficulty uint64
+}
+
+// PingPongPayload is the payload of both Ping and Pong; Pong echoes the Nonce it was sent.
+type PingPongPayload struct {
+	Nonce uint64
+}
+
+// FactomdEventForwardPayload carries an opaque, already-serialized factomd event. Its
+// contents are interpreted by the caller, not by the wire codec.
+type FactomdEventForwardPayload struct {
+	Event []byte
+}
+
+// Codec turns a NetworkMessage into bytes and back. Registered codecs are negotiated in the
+// Hello handshake (HelloPayload.Codec), so the same TCPServer can serve peers that prefer
+// different wire formats - e.g. "binary" for Go miners, "json" for easy debugging or
+// non-Go clients.
+type Codec interface {
+	Name() string
+	Encode(m *NetworkMessage) ([]byte, error)
+	Decode(b []byte) (*NetworkMessage, error)
+}
+
+var codecsByName = map[string]Codec{
+	"binary": binaryCodec{},
+	"json":   jsonCodec{},
+}
+
+// CodecByName looks up a registered Codec, defaulting to the binary codec when name is empty.
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		name = "binary"
+	}
+	c, ok := codecsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	return c, nil
+}
+
+// bootstrapCodec encodes the Hello/Ack/Nack exchange itself, before a peer's preferred codec
+// has been negotiated.
+var bootstrapCodec Codec = binaryCodec{}
+
+// payloadKind reports whether command is a recognized opcode, or an error if it isn't.
+// Centralizing this check is what lets us Nack an unknown opcode instead of dropping the
+// connection.
+func payloadKind(command int) (bool, error) {
+	switch command {
+	case Hello, Ack, Nack, NewOPRJobCmd, SubmitCmd, Ping, Pong, FactomdEventForward:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unrecognized command opcode %d", command)
+	}
+}
+
+// --- JSON codec -------------------------------------------------------------------------
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+type jsonEnvelope struct {
+	Command int             `json:"command"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+func (jsonCodec) Encode(m *NetworkMessage) ([]byte, error) {
+	payload, err := json.Marshal(m.Data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonEnvelope{Command: m.NetworkCommand, Payload: payload})
+}
+
+func (jsonCodec) Decode(b []byte) (*NetworkMessage, error) {
+	var env jsonEnvelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, err
+	}
+	if _, err := payloadKind(env.Command); err != nil {
+		return nil, err
+	}
+
+	data, err := unmarshalJSONPayload(env.Command, env.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &NetworkMessage{NetworkCommand: env.Command, Data: data}, nil
+}
+
+func unmarshalJSONPayload(command int, raw json.RawMessage) (interface{}, error) {
+	switch command {
+	case Hello:
+		var p HelloPayload
+		err := unmarshalIfPresent(raw, &p)
+		return p, err
+	case Nack:
+		var p string
+		err := unmarshalIfPresent(raw, &p)
+		return p, err
+	case NewOPRJobCmd:
+		var p NewOPRJob
+		err := unmarshalIfPresent(raw, &p)
+		return p, err
+	case SubmitCmd:
+		var p Submit
+		err := unmarshalIfPresent(raw, &p)
+		return p, err
+	case Ping, Pong:
+		var p PingPongPayload
+		err := unmarshalIfPresent(raw, &p)
+		return p, err
+	case FactomdEventForward:
+		var p FactomdEventForwardPayload
+		err := unmarshalIfPresent(raw, &p)
+		return p, err
+	case Ack:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unrecognized command opcode %d", command)
+	}
+}
+
+func unmarshalIfPresent(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// --- Binary codec -------------------------------------------------------------------------
+//
+// Frame layout (inside the length-prefixed transport frame from tcpServer.go):
+//
+//	byte[4]  command   (uint32, big-endian opcode from the constants above)
+//	byte[*]  payload    (opcode-specific, see the encode*/decode* helpers below)
+//
+// Every variable-length field (strings, byte slices) is itself length-prefixed with a
+// uint32, so a reader never needs to guess where a field ends.
+
+type binaryCodec struct{}
+
+func (binaryCodec) Name() string { return "binary" }
+
+func (binaryCodec) Encode(m *NetworkMessage) ([]byte, error) {
+	w := newBinWriter()
+	w.WriteUint32(uint32(m.NetworkCommand))
+
+	switch p := m.Data.(type) {
+	case HelloPayload:
+		w.WriteUint32(uint32(p.ProtoVersion))
+		w.WriteString(p.MinerID)
+		w.WriteUint64(p.Nonce)
+		w.WriteString(p.Codec)
+	case string: // Nack reason
+		w.WriteString(p)
+	case NewOPRJob:
+		w.WriteBytes(p.OPRHash)
+		w.WriteUint32(uint32(p.Dbht))
+		w.WriteUint64(p.Target)
+		w.WriteString(p.JobID)
+	case Submit:
+		w.WriteString(p.JobID)
+		w.WriteBytes(p.Nonce)
+		w.WriteUint64(p.Difficulty)
+	case PingPongPayload:
+		w.WriteUint64(p.Nonce)
+	case FactomdEventForwardPayload:
+		w.WriteBytes(p.Event)
+	case nil:
+		// Ack and similar commands carry no payload.
+	default:
+		return nil, fmt.Errorf("binary codec: no encoding for payload type %T", m.Data)
+	}
+
+	return w.Bytes(), w.err
+}
+
+func (binaryCodec) Decode(b []byte) (*NetworkMessage, error) {
+	r := newBinReader(b)
+	command := int(r.ReadUint32())
+	if _, err := payloadKind(command); err != nil {
+		return nil, err
+	}
+
+	var data interface{}
+	switch command {
+	case Hello:
+		var p HelloPayload
+		p.ProtoVersion = int(r.ReadUint32())
+		p.MinerID = r.ReadString()
+		p.Nonce = r.ReadUint64()
+		p.Codec = r.ReadString()
+		data = p
+	case Nack:
+		data = r.ReadString()
+	case NewOPRJobCmd:
+		var p NewOPRJob
+		p.OPRHash = r.ReadBytes()
+		p.Dbht = int32(r.ReadUint32())
+		p.Target = r.ReadUint64()
+		p.JobID = r.ReadString()
+		data = p
+	case SubmitCmd:
+		var p Submit
+		p.JobID = r.ReadString()
+		p.Nonce = r.ReadBytes()
+		p.Difficulty = r.ReadUint64()
+		data = p
+	case Ping, Pong:
+		var p PingPongPayload
+		p.Nonce = r.ReadUint64()
+		data = p
+	case FactomdEventForward:
+		var p FactomdEventForwardPayload
+		p.Event = r.ReadBytes()
+		data = p
+	case Ack:
+		data = nil
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &NetworkMessage{NetworkCommand: command, Data: data}, nil
+}
+
+// binWriter/binReader are tiny helpers around the length-prefixed primitives the binary codec
+// needs. They accumulate the first error they hit so call sites can check it once at the end
+// instead of after every field.
+
+type binWriter struct {
+	buf []byte
+	err error
+}
+
+func newBinWriter() *binWriter { return &binWriter{} }
+
+func (w *binWriter) WriteUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *binWriter) WriteUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *binWriter) WriteBytes(v []byte) {
+	w.WriteUint32(uint32(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+func (w *binWriter) WriteString(v string) {
+	w.WriteBytes([]byte(v))
+}
+
+func (w *binWriter) Bytes() []byte { return w.buf }
+
+type binReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func newBinReader(b []byte) *binReader { return &binReader{buf: b} }
+
+func (r *binReader) need(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if r.pos+n > len(r.buf) {
+		r.err = fmt.Errorf("binary codec: unexpected end of message, wanted %d more bytes", n)
+		return false
+	}
+	return true
+}
+
+func (r *binReader) ReadUint32() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf[r.pos : r.pos+4])
+	r.pos += 4
+	return v
+}
+
+func (r *binReader) ReadUint64() uint64 {
+	if !r.need(8) {
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.buf[r.pos : r.pos+8])
+	r.pos += 8
+	return v
+}
+
+func (r *binReader) ReadBytes() []byte {
+	n := r.ReadUint32()
+	if !r.need(int(n)) {
+		return nil
+	}
+	v := append([]byte(nil), r.buf[r.pos:r.pos+int(n)]...)
+	r.pos += int(n)
+	return v
+}
+
+func (r *binReader) ReadString() string {
+	return string(r.ReadBytes())
+}
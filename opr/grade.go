@@ -0,0 +1,27 @@
+package opr
+
+import (
+	"bytes"
+	"sort"
+)
+
+// GradeOPRs ranks a block of OPRs that share a directory block height by mining Difficulty
+// (highest first, OPRHash as a deterministic tiebreaker) and assigns each record's Grade to
+// its 1-indexed rank, so the winners a node selects for WinPreviousOPR don't depend on the
+// order records happened to arrive in.
+//
+// This trimmed tree has no per-asset rate-deviation grading (the full PegNet spec scores
+// submissions by how far their Assets diverge from their peers', not just by proof-of-work);
+// Difficulty is the only per-OPR quality signal present here, so it's what ranking is based
+// on until that scoring is ported over.
+func GradeOPRs(records []*OraclePriceRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Difficulty != records[j].Difficulty {
+			return records[i].Difficulty > records[j].Difficulty
+		}
+		return bytes.Compare(records[i].OPRHash, records[j].OPRHash) < 0
+	})
+	for i, r := range records {
+		r.Grade = float64(i + 1)
+	}
+}
@@ -4,6 +4,9 @@
 package opr
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -32,6 +35,10 @@ type OraclePriceRecord struct {
 	Entry      *factom.Entry     `json:"-"` // Entry to record this record
 	StopMining chan int          `json:"-"` // Bool that stops PegNet Mining this OPR
 
+	// MiningStrategy controls how Mine searches the nonce space. When nil, Mine defaults to
+	// a CPUPool sized off `[Miner] MiningThreads` (or runtime.NumCPU()).
+	MiningStrategy MiningStrategy `json:"-"`
+
 	// These values define the context of the OPR, and they go into the PegNet OPR record, and are mined.
 	OPRChainID         string     `json:"oprchainid"`      // [base58]  Chain ID of the chain used by the Oracle Miners
 	Dbht               int32      `json:"dbht"`            //           The Directory Block Height of the OPR.
@@ -41,6 +48,26 @@ type OraclePriceRecord struct {
 
 	// The Oracle values of the OPR, they are the meat of the OPR record, and are mined.
 	Assets OraclePriceRecordAssetList `json:"assets"`
+
+	// PubKey is the Ed25519 public key identifying this OPR's signer. It is part of the
+	// mined content, so FactomDigitalID's binding to a key can't be forged after the fact by
+	// swapping in a different PubKey once the OPRHash is known.
+	PubKey []byte `json:"pubkey,omitempty"`
+
+	// AssetQuotes holds the raw, per-source quotes behind every Assets value. It is part of
+	// the mined record - not debug-only - because it is what lets any other node recompute
+	// each asset's surviving source count and aggregate value for itself instead of trusting
+	// this miner's self-reported numbers; see Validate's `[Oracle] MinSources` check. It also
+	// lets miners and graders audit divergence between sources.
+	AssetQuotes map[string][]polling.Quote `json:"assetquotes"`
+
+	// Signature is the Ed25519 signature over Entry.Content - this record's exact mined
+	// bytes - made with the private key behind PubKey. It is carried out-of-band in
+	// Entry.ExtIDs[1] rather than in Content itself, so it never signs over itself.
+	Signature []byte `json:"-"`
+	// identityKey is held only long enough for sign to use it once GetOPRecord has finished
+	// building Entry.Content.
+	identityKey ed25519.PrivateKey
 }
 
 func NewOraclePriceRecord() *OraclePriceRecord {
@@ -113,6 +140,55 @@ func (opr *OraclePriceRecord) Validate(c *config.Config) bool {
 		return false // Missing some assets!
 	}
 
+	// Validate every asset was backed by enough independent sources that a single rogue or
+	// down API can't move the record on its own. The survivor count is recomputed from
+	// AssetQuotes rather than trusted from a self-reported field, since a miner running a
+	// single rogue source could otherwise just claim whatever count it likes. Survivors also
+	// have to name distinct, registered sources - otherwise a miner could pad AssetQuotes with
+	// fabricated entries clustered near a chosen value to manufacture however many "sources"
+	// MinSources demands.
+	if minSources, err := c.Int("Oracle.MinSources"); err == nil && minSources > 0 {
+		madK, err := c.Float64("Oracle.MADK")
+		if err != nil || madK <= 0 {
+			madK = 3.5
+		}
+		for _, asset := range common.AllAssets {
+			survivors := polling.RejectOutliers(opr.AssetQuotes[asset], madK)
+			seen := make(map[string]bool, len(survivors))
+			for _, q := range survivors {
+				if !polling.IsRegisteredSource(q.Source) || seen[q.Source] {
+					return false
+				}
+				seen[q.Source] = true
+			}
+			if len(seen) < minSources {
+				return false
+			}
+		}
+	}
+
+	// Reject unsigned or mis-signed OPRs, and OPRs signed by a key other than the one
+	// currently registered to their claimed FactomDigitalID, so a record can't steal another
+	// miner's reputation or coinbase attribution.
+	if enforce, _ := c.Bool("Miner.EnforceIdentity"); enforce {
+		// Signature travels out-of-band in Entry.ExtIDs[1] (see sign), not in the mined
+		// Content, so an OPR reconstructed from a chain entry on another node has it on
+		// Entry but not yet on Signature. Pull it across before verifying.
+		if len(opr.Signature) == 0 && opr.Entry != nil && len(opr.Entry.ExtIDs) > 1 {
+			opr.Signature = opr.Entry.ExtIDs[1]
+		}
+		if len(opr.PubKey) != ed25519.PublicKeySize || len(opr.Signature) != ed25519.SignatureSize {
+			return false
+		}
+		if !ed25519.Verify(opr.PubKey, opr.Entry.Content, opr.Signature) {
+			return false
+		}
+		registered, ok := common.Identities.Lookup(opr.FactomDigitalID)
+		if !ok || !bytes.Equal(registered, opr.PubKey) {
+			return false
+		}
+	}
+
 	return true
 }
 
@@ -133,56 +209,43 @@ func (opr *OraclePriceRecord) GetHash() []byte {
 // appended by the nonce. The difficulty is the highest 8 bytes of the hash
 // taken as uint64 in Big Endian
 func (opr *OraclePriceRecord) ComputeDifficulty(nonce []byte) (difficulty uint64) {
-	no := append(opr.OPRHash, nonce...)
+	no := append(append([]byte{}, opr.OPRHash...), nonce...)
 	h := LX.Hash(no)
+	return difficultyFromHash(h)
+}
 
-	// The high eight bytes of the hash(hash(entry.Content) + nonce) is the difficulty.
-	// Because we don't have a difficulty bar, we can define difficulty as the greatest
-	// value, rather than the minimum value.  Our bar is the greatest difficulty found
-	// within a 10 minute period.  We compute difficulty as Big Endian.
-	for i := uint64(0); i < 8; i++ {
+// difficultyFromHash extracts the difficulty from an LXRHash digest: the high eight bytes,
+// taken as uint64 in Big Endian. Because we don't have a difficulty bar, we can define
+// difficulty as the greatest value, rather than the minimum value.  Our bar is the greatest
+// difficulty found within a 10 minute period.
+func difficultyFromHash(h []byte) (difficulty uint64) {
+	for i := 0; i < 8; i++ {
 		difficulty = difficulty<<8 + uint64(h[i])
 	}
 	return difficulty
 }
 
-// Mine calculates difficulties with varying nonces, keeping track of the
-// highest difficulty achieved in the Difficulty and ExtID[0] fields
-// Stops when a signal is received on the StopMining channel.
+// Mine searches the nonce space for the best difficulty, keeping track of the highest
+// difficulty achieved in the Difficulty and Entry.ExtIDs[0] fields. It delegates the actual
+// search to opr.MiningStrategy (CPUPool by default, sized off `[Miner] MiningThreads` or
+// runtime.NumCPU), and stops when a signal is received on the StopMining channel.
 func (opr *OraclePriceRecord) Mine(verbose bool) {
-
-	// Pick a new nonce as a starting point.  Take time + last best nonce and hash that.
-	nonce := []byte{0, 0}
 	log.WithFields(log.Fields{"opr_hash": hex.EncodeToString(opr.OPRHash)}).Debug("Started mining")
 
-	var i uint64
-	var diff uint64
-miningloop:
-	for i = 0; ; i++ {
-		select {
-		case <-opr.StopMining:
-			break miningloop
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-opr.StopMining
+		cancel()
+	}()
+	defer cancel()
 
-		default:
-		}
-		nonce = nonce[:0]
-		for j := i; j > 0; j = j >> 8 {
-			nonce = append(nonce, byte(j))
-		}
-		diff = opr.ComputeDifficulty(nonce)
-
-		if diff > opr.Difficulty {
-			opr.Difficulty = diff
-			// Copy over the previous nonce
-			opr.Entry.ExtIDs[0] = append(opr.Entry.ExtIDs[0][:0], nonce...)
-			log.WithFields(log.Fields{
-				"opr_hash":   hex.EncodeToString(opr.OPRHash),
-				"difficulty": diff,
-				"nonce":      hex.EncodeToString(nonce),
-			}).Debug("Mined OPR")
-		}
+	strategy := opr.MiningStrategy
+	if strategy == nil {
+		strategy = NewCPUPool(opr.Config)
 	}
-	common.Stats.Update(i, opr.Difficulty)
+	strategy.Mine(ctx, opr)
+
+	log.WithFields(opr.LogFieldsShort()).Debug("Mined OPR")
 }
 
 // ShortString returns a human readable string with select data
@@ -258,10 +321,14 @@ func (opr *OraclePriceRecord) LogFieldsShort() log.Fields {
 	}
 }
 
-// SetPegValues assigns currency polling values to the OPR
+// SetPegValues assigns currency polling values to the OPR, along with the raw per-source
+// quotes that were aggregated into each value, so another node can independently recompute
+// the aggregate instead of trusting this miner's Assets values outright.
 func (opr *OraclePriceRecord) SetPegValues(assets polling.PegAssets) {
+	opr.AssetQuotes = make(map[string][]polling.Quote, len(assets))
 	for asset, v := range assets {
 		opr.Assets[asset] = v.Value
+		opr.AssetQuotes[asset] = v.Quotes
 	}
 }
 
@@ -356,6 +423,10 @@ func (opr *OraclePriceRecord) GetOPRecord(c *config.Config) {
 	Peg = polling.PullPEGAssets(c)
 	opr.SetPegValues(Peg)
 
+	if err := opr.loadIdentity(c); err != nil {
+		log.WithError(err).Warn("continuing without a signed miner identity")
+	}
+
 	var err error
 	opr.Entry = new(factom.Entry)
 	opr.Entry.ChainID = hex.EncodeToString(base58.Decode(opr.OPRChainID))
@@ -365,4 +436,51 @@ func (opr *OraclePriceRecord) GetOPRecord(c *config.Config) {
 		panic(err)
 	}
 	opr.OPRHash = LX.Hash(opr.Entry.Content)
+
+	opr.sign()
+}
+
+// loadIdentity reads `[Miner] IdentityPrivateKey` (a hex-encoded Ed25519 seed or private
+// key) and, if present, derives PubKey from it ahead of marshaling Entry.Content so the key
+// is bound into the mined record. Signing itself happens later, in sign, once Entry.Content
+// exists. An unset IdentityPrivateKey is not an error: signing is only mandatory when
+// `[Miner] EnforceIdentity` is set on the validating side.
+func (opr *OraclePriceRecord) loadIdentity(c *config.Config) error {
+	keyHex, err := c.String("Miner.IdentityPrivateKey")
+	if err != nil || keyHex == "" {
+		return nil
+	}
+
+	seed, err := hex.DecodeString(keyHex)
+	if err != nil {
+		return fmt.Errorf("decoding Miner.IdentityPrivateKey: %v", err)
+	}
+
+	switch len(seed) {
+	case ed25519.SeedSize:
+		opr.identityKey = ed25519.NewKeyFromSeed(seed)
+	case ed25519.PrivateKeySize:
+		opr.identityKey = ed25519.PrivateKey(seed)
+	default:
+		return fmt.Errorf("Miner.IdentityPrivateKey must hex-decode to %d or %d bytes, got %d",
+			ed25519.SeedSize, ed25519.PrivateKeySize, len(seed))
+	}
+
+	opr.PubKey = opr.identityKey.Public().(ed25519.PublicKey)
+	return nil
+}
+
+// sign signs Entry.Content with identityKey, if loadIdentity found one, and stashes the
+// resulting signature in both Signature and Entry.ExtIDs[1] for transmission alongside the
+// nonce in Entry.ExtIDs[0].
+func (opr *OraclePriceRecord) sign() {
+	if opr.identityKey == nil {
+		return
+	}
+
+	opr.Signature = ed25519.Sign(opr.identityKey, opr.Entry.Content)
+	for len(opr.Entry.ExtIDs) < 2 {
+		opr.Entry.ExtIDs = append(opr.Entry.ExtIDs, []byte{})
+	}
+	opr.Entry.ExtIDs[1] = opr.Signature
 }
@@ -0,0 +1,79 @@
+package polling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zpatrick/go-config"
+)
+
+func init() {
+	RegisterSource("APILayer", newAPILayerSource)
+}
+
+// apiLayerSource quotes fiat currencies against USD via APILayer's currency_data "live" rates
+// endpoint. It requires `[Oracle] APILayerKey` to be set; the factory fails fast if it isn't,
+// the same way an unset EC/FCT address fails fast in NewOpr, rather than registering a source
+// that can only ever error at fetch time.
+type apiLayerSource struct {
+	client *http.Client
+	apiKey string
+}
+
+func newAPILayerSource(c *config.Config) (Source, error) {
+	apiKey, err := c.String("Oracle.APILayerKey")
+	if err != nil || apiKey == "" {
+		return nil, fmt.Errorf("Oracle.APILayerKey is not set")
+	}
+	return &apiLayerSource{client: &http.Client{Timeout: 10 * time.Second}, apiKey: apiKey}, nil
+}
+
+func (s *apiLayerSource) Name() string { return "APILayer" }
+
+type apiLayerResponse struct {
+	Success bool               `json:"success"`
+	Source  string             `json:"source"`
+	Quotes  map[string]float64 `json:"quotes"`
+	Error   struct {
+		Info string `json:"info"`
+	} `json:"error"`
+}
+
+// FetchQuotes implements Source. Quotes come back keyed "<source><target>" (e.g. "USDEUR");
+// the source prefix is stripped so the map is keyed by the target ticker alone, matching
+// every other Source's convention.
+func (s *apiLayerSource) FetchQuotes() (map[string]Quote, error) {
+	req, err := http.NewRequest("GET", "https://api.apilayer.com/currency_data/live?source=USD", nil)
+	if err != nil {
+		return nil, fmt.Errorf("building apilayer request: %v", err)
+	}
+	req.Header.Set("apikey", s.apiKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching apilayer rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var parsed apiLayerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("decoding apilayer response: %v", err)
+	}
+	if !parsed.Success {
+		return nil, fmt.Errorf("apilayer request failed: %s", parsed.Error.Info)
+	}
+
+	now := time.Now()
+	quotes := make(map[string]Quote, len(parsed.Quotes))
+	for pair, rate := range parsed.Quotes {
+		asset := strings.TrimPrefix(pair, parsed.Source)
+		if asset == "" || asset == pair {
+			continue
+		}
+		quotes[asset] = Quote{Source: s.Name(), Value: rate, Timestamp: now, Weight: 1}
+	}
+	return quotes, nil
+}
@@ -0,0 +1,63 @@
+package polling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/zpatrick/go-config"
+)
+
+func init() {
+	RegisterSource("CoinCap", newCoinCapSource)
+}
+
+// coinCapSource quotes crypto assets off CoinCap's public REST API
+// (https://api.coincap.io/v2/assets), keyed by each asset's ticker symbol (BTC, ETH, ...). It
+// needs no API key, so its factory never fails.
+type coinCapSource struct {
+	client *http.Client
+}
+
+func newCoinCapSource(c *config.Config) (Source, error) {
+	return &coinCapSource{client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (s *coinCapSource) Name() string { return "CoinCap" }
+
+type coinCapResponse struct {
+	Data []struct {
+		Symbol   string `json:"symbol"`
+		PriceUSD string `json:"priceUsd"`
+	} `json:"data"`
+}
+
+// FetchQuotes implements Source.
+func (s *coinCapSource) FetchQuotes() (map[string]Quote, error) {
+	resp, err := s.client.Get("https://api.coincap.io/v2/assets")
+	if err != nil {
+		return nil, fmt.Errorf("fetching coincap assets: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var parsed coinCapResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("decoding coincap response: %v", err)
+	}
+
+	now := time.Now()
+	quotes := make(map[string]Quote, len(parsed.Data))
+	for _, a := range parsed.Data {
+		if a.Symbol == "" {
+			continue
+		}
+		price, err := strconv.ParseFloat(a.PriceUSD, 64)
+		if err != nil {
+			continue
+		}
+		quotes[a.Symbol] = Quote{Source: s.Name(), Value: price, Timestamp: now, Weight: 1}
+	}
+	return quotes, nil
+}
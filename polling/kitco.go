@@ -0,0 +1,67 @@
+package polling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zpatrick/go-config"
+)
+
+func init() {
+	RegisterSource("Kitco", newKitcoSource)
+}
+
+// kitcoSource quotes precious-metal spot prices (gold, silver, platinum, palladium) off
+// Kitco's public price-feed JSON endpoint, keyed by the standard ISO 4217 metal tickers
+// (XAU, XAG, XPT, XPD). It needs no API key, so its factory never fails.
+type kitcoSource struct {
+	client *http.Client
+}
+
+func newKitcoSource(c *config.Config) (Source, error) {
+	return &kitcoSource{client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (s *kitcoSource) Name() string { return "Kitco" }
+
+// kitcoSymbols maps Kitco's own metal codes to the ISO 4217 ticker PegNet's Assets use.
+var kitcoSymbols = map[string]string{
+	"au": "XAU",
+	"ag": "XAG",
+	"pt": "XPT",
+	"pd": "XPD",
+}
+
+type kitcoResponse struct {
+	Results []struct {
+		Symbol string  `json:"symbol"`
+		Bid    float64 `json:"bid"`
+	} `json:"results"`
+}
+
+// FetchQuotes implements Source.
+func (s *kitcoSource) FetchQuotes() (map[string]Quote, error) {
+	resp, err := s.client.Get("https://www.kitco.com/market/metals")
+	if err != nil {
+		return nil, fmt.Errorf("fetching kitco quotes: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var parsed kitcoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("decoding kitco response: %v", err)
+	}
+
+	now := time.Now()
+	quotes := make(map[string]Quote, len(parsed.Results))
+	for _, r := range parsed.Results {
+		asset, ok := kitcoSymbols[r.Symbol]
+		if !ok || r.Bid <= 0 {
+			continue
+		}
+		quotes[asset] = Quote{Source: s.Name(), Value: r.Bid, Timestamp: now, Weight: 1}
+	}
+	return quotes, nil
+}
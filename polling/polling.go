@@ -0,0 +1,307 @@
+// Package polling pulls asset prices from a registry of pluggable sources and combines them
+// into the PegAssets consumed by an OraclePriceRecord.
+package polling
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zpatrick/go-config"
+)
+
+// Quote is a single asset price as reported by one Source.
+type Quote struct {
+	Source    string    `json:"source"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+	// Weight is the source's confidence in this quote. Higher weight pulls the weighted
+	// median further towards this quote. Sources that don't have an opinion can just use 1.
+	Weight float64 `json:"weight"`
+}
+
+// Source is a single price feed, e.g. an exchange or aggregator API. Implementations are
+// looked up by name through RegisterSource/PullPEGAssets; they should not block longer than
+// necessary since PullPEGAssets enforces a per-source timeout around the call.
+type Source interface {
+	// Name identifies the source in config (the `[Oracle]` enable flag) and in logs.
+	Name() string
+	// FetchQuotes returns the latest quote for every asset this source knows about.
+	FetchQuotes() (map[string]Quote, error)
+}
+
+// PegItem is the aggregated value for a single asset, along with enough provenance to audit
+// how it was computed.
+type PegItem struct {
+	Value float64 `json:"value"`
+
+	// SourceCount is how many sources survived outlier rejection and contributed to Value,
+	// as computed locally by the polling miner. It is informational only: a self-reported
+	// count can't be trusted by anyone else, so Validate's `[Oracle] MinSources` guard
+	// recomputes survivorship itself from Quotes (see RejectOutliers) rather than reading
+	// this field.
+	SourceCount int `json:"sourcecount"`
+
+	// Quotes holds every quote considered for this asset, survivors and outliers alike. It
+	// travels with the OPR (not debug-only) because it is exactly what lets another node
+	// recompute SourceCount and Value independently instead of trusting this miner's say-so.
+	Quotes []Quote `json:"quotes"`
+}
+
+// PegAssets is the aggregated, per-asset view handed to an OraclePriceRecord.
+type PegAssets map[string]PegItem
+
+type sourceFactory func(c *config.Config) (Source, error)
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]sourceFactory{}
+)
+
+// RegisterSource adds a price source to the registry under name. It is enabled for a given
+// miner by setting `[Oracle] <name>=1` in that miner's config, matching the existing
+// CoinCap/APILayer/Kitco style flags. Sources normally call this from an init() function.
+func RegisterSource(name string, factory sourceFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// IsRegisteredSource reports whether name was registered via RegisterSource - i.e. it names a
+// source this binary actually knows how to fetch from, rather than a name a miner made up.
+// Validate's `[Oracle] MinSources` check uses this to make sure survivor Quotes claim to come
+// from real sources, not fabricated ones.
+func IsRegisteredSource(name string) bool {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	_, ok := registry[name]
+	return ok
+}
+
+const (
+	// defaultSourceTimeout bounds how long we wait on any single source before treating it
+	// as absent for this poll.
+	defaultSourceTimeout = 5 * time.Second
+	// defaultMaxQuoteAge drops quotes a source returns that are older than this; a source
+	// that's stuck serving a cached/stale price shouldn't silently drag the median with it.
+	defaultMaxQuoteAge = 30 * time.Second
+	// defaultMADK is the default outlier cutoff, in median absolute deviations.
+	defaultMADK = 3.5
+)
+
+// PullPEGAssets fans out to every `[Oracle]`-enabled source in parallel, then combines the
+// resulting quotes per asset: quotes older than MaxQuoteAgeSeconds are dropped, quotes more
+// than MADK median-absolute-deviations from the median are rejected as outliers, and the
+// weighted median of the survivors becomes PegItem.Value.
+func PullPEGAssets(c *config.Config) PegAssets {
+	sources := enabledSources(c)
+	timeout := durationConfig(c, "Oracle.SourceTimeoutMS", defaultSourceTimeout)
+	maxAge := durationConfig(c, "Oracle.MaxQuoteAgeSeconds", defaultMaxQuoteAge)
+	madK := floatConfig(c, "Oracle.MADK", defaultMADK)
+
+	quotesByAsset := fetchAll(sources, c, timeout)
+
+	now := time.Now()
+	assets := make(PegAssets)
+	for asset, quotes := range quotesByAsset {
+		fresh := make([]Quote, 0, len(quotes))
+		for _, q := range quotes {
+			if now.Sub(q.Timestamp) <= maxAge {
+				fresh = append(fresh, q)
+			}
+		}
+
+		survivors := rejectOutliers(fresh, madK)
+		assets[asset] = PegItem{
+			Value:       weightedMedian(survivors),
+			SourceCount: len(survivors),
+			Quotes:      fresh,
+		}
+	}
+
+	return assets
+}
+
+// enabledSources builds a Source for every registered name enabled via `[Oracle] <name>=1`,
+// in name order for deterministic iteration. Names whose factory fails are logged and skipped.
+func enabledSources(c *config.Config) []Source {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryMu.Unlock()
+	sort.Strings(names)
+
+	var sources []Source
+	for _, name := range names {
+		on, err := c.Int(fmt.Sprintf("Oracle.%s", name))
+		if err != nil || on == 0 {
+			continue
+		}
+
+		registryMu.Lock()
+		factory := registry[name]
+		registryMu.Unlock()
+
+		source, err := factory(c)
+		if err != nil {
+			log.WithError(err).WithField("source", name).Error("failed to initialize oracle source")
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// fetchAll queries every source concurrently, bounding each by timeout, and groups the
+// resulting quotes by asset.
+func fetchAll(sources []Source, c *config.Config, timeout time.Duration) map[string][]Quote {
+	type result struct {
+		quotes map[string]Quote
+		err    error
+		name   string
+	}
+
+	results := make(chan result, len(sources))
+	for _, s := range sources {
+		go func(s Source) {
+			done := make(chan struct{})
+			var quotes map[string]Quote
+			var err error
+			go func() {
+				quotes, err = s.FetchQuotes()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				results <- result{quotes: quotes, err: err, name: s.Name()}
+			case <-time.After(timeout):
+				results <- result{err: fmt.Errorf("timed out after %s", timeout), name: s.Name()}
+			}
+		}(s)
+	}
+
+	byAsset := make(map[string][]Quote)
+	for range sources {
+		r := <-results
+		if r.err != nil {
+			log.WithError(r.err).WithField("source", r.name).Warn("oracle source poll failed")
+			continue
+		}
+		for asset, q := range r.quotes {
+			byAsset[asset] = append(byAsset[asset], q)
+		}
+	}
+	return byAsset
+}
+
+// RejectOutliers is the exported form of the aggregator's outlier rejection. Validate uses it
+// to recompute, from the OPR's own AssetQuotes, how many sources actually survive for
+// `[Oracle] MinSources` - rather than trusting the self-reported PegItem.SourceCount, which a
+// miner running a single rogue source could just set to whatever it likes.
+func RejectOutliers(quotes []Quote, k float64) []Quote {
+	return rejectOutliers(quotes, k)
+}
+
+// rejectOutliers drops quotes more than k median-absolute-deviations from the median. With
+// fewer than 3 quotes there isn't enough data to call anything an outlier, so all are kept.
+func rejectOutliers(quotes []Quote, k float64) []Quote {
+	if len(quotes) < 3 {
+		return quotes
+	}
+
+	values := make([]float64, len(quotes))
+	for i, q := range quotes {
+		values[i] = q.Value
+	}
+	med := median(values)
+
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = absFloat(v - med)
+	}
+	mad := median(deviations)
+
+	survivors := make([]Quote, 0, len(quotes))
+	for i, q := range quotes {
+		// Compared as a product so a zero MAD (at least half the quotes sitting exactly on the
+		// median) keeps only those exact matches and rejects everything off the median.
+		if deviations[i] <= k*mad {
+			survivors = append(survivors, q)
+		}
+	}
+	return survivors
+}
+
+// weightedMedian returns the weighted median value of quotes, or 0 if quotes is empty.
+func weightedMedian(quotes []Quote) float64 {
+	if len(quotes) == 0 {
+		return 0
+	}
+
+	sorted := append([]Quote(nil), quotes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+
+	var totalWeight float64
+	for _, q := range sorted {
+		w := q.Weight
+		if w <= 0 {
+			w = 1
+		}
+		totalWeight += w
+	}
+
+	var cumulative float64
+	for _, q := range sorted {
+		w := q.Weight
+		if w <= 0 {
+			w = 1
+		}
+		cumulative += w
+		if cumulative >= totalWeight/2 {
+			return q.Value
+		}
+	}
+	return sorted[len(sorted)-1].Value
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func absFloat(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
+
+func durationConfig(c *config.Config, key string, def time.Duration) time.Duration {
+	if key[len(key)-2:] == "MS" {
+		if ms, err := c.Int(key); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		return def
+	}
+	if secs, err := c.Int(key); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return def
+}
+
+func floatConfig(c *config.Config, key string, def float64) float64 {
+	if f, err := c.Float64(key); err == nil && f > 0 {
+		return f
+	}
+	return def
+}
@@ -0,0 +1,366 @@
+// Package stratum runs a Stratum-like, line-delimited JSON-RPC server alongside
+// networkMiner.TCPServer so external miner software (not just the in-process Go miner) can
+// pull OPR jobs and submit shares over a plain TCP socket.
+package stratum
+
+import (
+	"bufio"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pegnet/pegnet/opr"
+)
+
+// Request is a single JSON-RPC request/notification line, e.g.
+// {"id":1,"method":"mining.subscribe","params":[]}
+type Request struct {
+	ID     interface{}     `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// Response answers a Request with the same ID.
+type Response struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result,omitempty"`
+	Error  *RPCError   `json:"error,omitempty"`
+}
+
+// RPCError is a Stratum-style [code, message, traceback] error, trimmed to what we use.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Notification is a server-initiated message with no ID, e.g. mining.notify / mining.set_target.
+type Notification struct {
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+// NotifyParams is the payload of a mining.notify pushed whenever the current job changes.
+type NotifyParams struct {
+	JobID     string `json:"job_id"`
+	OPRHash   string `json:"opr_hash_hex"`
+	Target    uint64 `json:"target"`
+	Dbht      int32  `json:"dbht"`
+	CleanJobs bool   `json:"clean_jobs"`
+}
+
+// SetTargetParams is the payload of a per-worker mining.set_target vardiff adjustment.
+type SetTargetParams struct {
+	Target uint64 `json:"target"`
+}
+
+const (
+	// initialShareDifficulty is the share target new workers start at, well below typical
+	// block difficulty so a worker gets its first share (and therefore its first vardiff
+	// adjustment) quickly.
+	initialShareDifficulty = 1 << 24
+	minShareDifficulty     = 1 << 16
+	// maxShareDifficulty ceilings adjustVardiffLocked's doubling so a worker submitting far
+	// faster than targetShareInterval can't be walked past the uint64 range.
+	maxShareDifficulty = 1 << 62
+
+	// targetShareInterval is the submission rate vardiff aims for. Workers submitting much
+	// faster get a harder share target; much slower get an easier one.
+	targetShareInterval = 10 * time.Second
+)
+
+// Worker is one connected Stratum client - typically one external miner process.
+type Worker struct {
+	id   string
+	conn net.Conn
+	enc  *json.Encoder
+
+	mu                 sync.Mutex
+	coinbasePNTAddress string
+	identity           string
+	shareDifficulty    uint64
+	acceptedShares     uint64
+	lastSubmit         time.Time
+}
+
+// AcceptedShares returns how many shares this worker has had accepted, for pool-style payout
+// accounting against its CoinbasePNTAddress.
+func (w *Worker) AcceptedShares() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.acceptedShares
+}
+
+// CoinbasePNTAddress returns the PNT address this worker authorized with.
+func (w *Worker) CoinbasePNTAddress() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.coinbasePNTAddress
+}
+
+// Server is the Stratum-like coordinator side. It tracks the current OPR job and every
+// connected Worker, and forwards the single highest-difficulty nonce seen for the current job
+// to OnBestShare (normally wired up to the existing Entry.ExtIDs[0] submission path).
+type Server struct {
+	mu         sync.Mutex
+	workers    map[string]*Worker
+	currentOPR *opr.OraclePriceRecord
+	jobID      int
+	bestDiff   uint64
+
+	// OnBestShare is called whenever a submitted share beats every share seen so far for the
+	// current job. nonce is the raw bytes to place in Entry.ExtIDs[0].
+	OnBestShare func(worker *Worker, nonce []byte, difficulty uint64)
+}
+
+// NewServer creates an empty Server. Call SetCurrentJob once a job is available and Listen to
+// start accepting workers.
+func NewServer() *Server {
+	return &Server{workers: make(map[string]*Worker)}
+}
+
+// UseOPRSubmission installs an OnBestShare handler that writes a winning share straight into
+// o's Entry.ExtIDs[0] and Difficulty - the same two fields CPUPool.Mine itself updates - so a
+// nonce an external Stratum worker finds is submitted exactly as if the in-process CPU miner
+// had found it. o must be the same record passed to SetCurrentJob.
+func (s *Server) UseOPRSubmission(o *opr.OraclePriceRecord) {
+	s.OnBestShare = func(worker *Worker, nonce []byte, difficulty uint64) {
+		if difficulty <= o.Difficulty {
+			return
+		}
+		o.Difficulty = difficulty
+		o.Entry.ExtIDs[0] = nonce
+	}
+}
+
+// Listen accepts Stratum connections on host until the listener errors.
+func (s *Server) Listen(host string) error {
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		return fmt.Errorf("stratum: listen: %v", err)
+	}
+	log.Info("Stratum server listening on ", host)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.WithError(err).Error("stratum: failed to accept worker")
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// SetCurrentJob installs o as the job workers mine against and pushes mining.notify (with
+// clean_jobs=true, since a new OPR always invalidates in-flight shares) to every worker.
+func (s *Server) SetCurrentJob(o *opr.OraclePriceRecord) {
+	s.mu.Lock()
+	s.currentOPR = o
+	s.jobID++
+	s.bestDiff = 0
+	jobID := fmt.Sprintf("%d", s.jobID)
+	workers := make([]*Worker, 0, len(s.workers))
+	for _, w := range s.workers {
+		workers = append(workers, w)
+	}
+	s.mu.Unlock()
+
+	params := NotifyParams{
+		JobID:     jobID,
+		OPRHash:   hex.EncodeToString(o.OPRHash),
+		Target:    initialShareDifficulty,
+		Dbht:      o.Dbht,
+		CleanJobs: true,
+	}
+	for _, w := range workers {
+		w.notify("mining.notify", params)
+		w.mu.Lock()
+		target := w.shareDifficulty
+		w.mu.Unlock()
+		w.notify("mining.set_target", SetTargetParams{Target: target})
+	}
+}
+
+// currentJob returns the job params for the most recently set job and whether one has been
+// set yet, for sending to a worker as soon as it subscribes rather than making it wait for the
+// next SetCurrentJob call.
+func (s *Server) currentJob() (NotifyParams, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.currentOPR == nil {
+		return NotifyParams{}, false
+	}
+	return NotifyParams{
+		JobID:     fmt.Sprintf("%d", s.jobID),
+		OPRHash:   hex.EncodeToString(s.currentOPR.OPRHash),
+		Target:    initialShareDifficulty,
+		Dbht:      s.currentOPR.Dbht,
+		CleanJobs: true,
+	}, true
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	w := &Worker{
+		id:              conn.RemoteAddr().String(),
+		conn:            conn,
+		enc:             json.NewEncoder(conn),
+		shareDifficulty: initialShareDifficulty,
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			w.reply(nil, nil, &RPCError{Code: 20, Message: "invalid JSON-RPC request"})
+			continue
+		}
+		s.dispatch(w, &req)
+	}
+
+	s.mu.Lock()
+	delete(s.workers, w.id)
+	s.mu.Unlock()
+	conn.Close()
+}
+
+func (s *Server) dispatch(w *Worker, req *Request) {
+	switch req.Method {
+	case "mining.subscribe":
+		s.mu.Lock()
+		s.workers[w.id] = w
+		s.mu.Unlock()
+		w.reply(req.ID, []string{w.id, randomExtranonce()}, nil)
+
+		// A job may already be running (SetCurrentJob fired before this worker connected);
+		// send it now instead of leaving the worker idle until the next job change.
+		if job, ok := s.currentJob(); ok {
+			w.notify("mining.notify", job)
+			w.mu.Lock()
+			target := w.shareDifficulty
+			w.mu.Unlock()
+			w.notify("mining.set_target", SetTargetParams{Target: target})
+		}
+
+	case "mining.authorize":
+		var params []string
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params) < 1 {
+			w.reply(req.ID, nil, &RPCError{Code: 21, Message: "mining.authorize requires [coinbase_pnt_address, identity]"})
+			return
+		}
+		w.mu.Lock()
+		w.coinbasePNTAddress = params[0]
+		if len(params) > 1 {
+			w.identity = params[1]
+		}
+		w.mu.Unlock()
+		w.reply(req.ID, true, nil)
+
+	case "mining.submit":
+		s.handleSubmit(w, req)
+
+	default:
+		w.reply(req.ID, nil, &RPCError{Code: 22, Message: fmt.Sprintf("unknown method %q", req.Method)})
+	}
+}
+
+type submitParams struct {
+	JobID    string `json:"job_id"`
+	NonceHex string `json:"nonce_hex"`
+}
+
+func (s *Server) handleSubmit(w *Worker, req *Request) {
+	var params submitParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		w.reply(req.ID, nil, &RPCError{Code: 23, Message: "malformed mining.submit params"})
+		return
+	}
+
+	nonce, err := hex.DecodeString(params.NonceHex)
+	if err != nil {
+		w.reply(req.ID, nil, &RPCError{Code: 24, Message: "nonce_hex is not valid hex"})
+		return
+	}
+
+	s.mu.Lock()
+	currentOPR := s.currentOPR
+	jobID := fmt.Sprintf("%d", s.jobID)
+	s.mu.Unlock()
+
+	if currentOPR == nil || params.JobID != jobID {
+		w.reply(req.ID, map[string]interface{}{"accepted": false}, &RPCError{Code: 25, Message: "stale job_id"})
+		return
+	}
+
+	difficulty := currentOPR.ComputeDifficulty(nonce)
+
+	w.mu.Lock()
+	accepted := difficulty >= w.shareDifficulty
+	if accepted {
+		w.acceptedShares++
+	}
+	w.adjustVardiffLocked()
+	newTarget := w.shareDifficulty
+	w.mu.Unlock()
+
+	w.reply(req.ID, map[string]interface{}{"accepted": accepted, "difficulty": difficulty}, nil)
+	w.notify("mining.set_target", SetTargetParams{Target: newTarget})
+
+	s.mu.Lock()
+	isBest := difficulty > s.bestDiff
+	if isBest {
+		s.bestDiff = difficulty
+	}
+	s.mu.Unlock()
+
+	if isBest && s.OnBestShare != nil {
+		s.OnBestShare(w, nonce, difficulty)
+	}
+}
+
+// adjustVardiffLocked retargets shareDifficulty based on how long it has been since the
+// worker's previous submit (accepted or not), aiming for roughly one share every targetShareInterval.
+// w.mu must be held.
+func (w *Worker) adjustVardiffLocked() {
+	now := time.Now()
+	if !w.lastSubmit.IsZero() {
+		elapsed := now.Sub(w.lastSubmit)
+		switch {
+		case elapsed < targetShareInterval/2:
+			w.shareDifficulty *= 2
+			if w.shareDifficulty > maxShareDifficulty {
+				w.shareDifficulty = maxShareDifficulty
+			}
+		case elapsed > targetShareInterval*2 && w.shareDifficulty > minShareDifficulty:
+			w.shareDifficulty /= 2
+			if w.shareDifficulty < minShareDifficulty {
+				w.shareDifficulty = minShareDifficulty
+			}
+		}
+	}
+	w.lastSubmit = now
+}
+
+func (w *Worker) reply(id interface{}, result interface{}, rpcErr *RPCError) {
+	_ = w.enc.Encode(Response{ID: id, Result: result, Error: rpcErr})
+}
+
+func (w *Worker) notify(method string, params interface{}) {
+	_ = w.enc.Encode(Notification{Method: method, Params: params})
+}
+
+// randomExtranonce hands each subscribing worker a distinct prefix to mix into its nonce
+// search space, the same role extranonce1 plays in Bitcoin/Ethereum Stratum pools.
+func randomExtranonce() string {
+	var b [4]byte
+	if _, err := cryptorand.Read(b[:]); err != nil {
+		// Falling back to a fixed prefix only costs us search-space overlap between workers,
+		// never correctness, so it's fine to keep serving instead of failing the subscribe.
+		return "00000000"
+	}
+	return hex.EncodeToString(b[:])
+}